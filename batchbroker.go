@@ -0,0 +1,12 @@
+package msgqueue
+
+// BatchBroker is implemented by brokers that can reserve and delete
+// messages in bulk instead of one at a time, e.g. SQS's
+// ReceiveMessage/DeleteMessageBatch. Processors do not check for it and
+// always call ReserveN/Delete/Release; a broker such as sqs.Queue routes
+// those through its own batch methods to cut broker round trips.
+type BatchBroker interface {
+	ReserveBatchN(n int) ([]*Message, error)
+	DeleteBatch(msgs []*Message) error
+	ReleaseBatch(msgs []*Message) error
+}
@@ -0,0 +1,103 @@
+package msgqueue
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/go-redis/redis/v8"
+	"github.com/google/uuid"
+
+	"github.com/go-msgqueue/msgqueue/internal"
+)
+
+// blobRefPrefix tags a Body that has been spilled to a BlobStore, so
+// RestoreIfSpilled can tell a reference apart from an inline payload
+// without a broker-specific flag.
+const blobRefPrefix = "msgqueue:blobref:"
+
+// BlobStore holds payloads too large for the broker itself, so
+// Options.MaxPayloadBytes can be enforced transparently: Queue.Add
+// spills the body here and writes a small reference in its place, and
+// the queue restores it when the message is reserved, before the
+// Handler runs.
+type BlobStore interface {
+	Put(ctx context.Context, key string, body []byte) error
+	Get(ctx context.Context, key string) ([]byte, error)
+	// Delete removes key. RestoreIfSpilled calls this once it has read a
+	// spilled body back, so a store never accumulates one blob per
+	// oversized message forever.
+	Delete(ctx context.Context, key string) error
+}
+
+// RedisBlobStore is the default BlobStore, for deployments that would
+// rather not add S3 credentials just to get past a broker's size limit.
+type RedisBlobStore struct {
+	rdb redis.Cmdable
+}
+
+var _ BlobStore = (*RedisBlobStore)(nil)
+
+func NewRedisBlobStore(rdb redis.Cmdable) *RedisBlobStore {
+	return &RedisBlobStore{rdb: rdb}
+}
+
+func (s *RedisBlobStore) Put(ctx context.Context, key string, body []byte) error {
+	return s.rdb.Set(ctx, blobKey(key), body, 0).Err()
+}
+
+func (s *RedisBlobStore) Get(ctx context.Context, key string) ([]byte, error) {
+	return s.rdb.Get(ctx, blobKey(key)).Bytes()
+}
+
+func (s *RedisBlobStore) Delete(ctx context.Context, key string) error {
+	return s.rdb.Del(ctx, blobKey(key)).Err()
+}
+
+func blobKey(key string) string {
+	return "msgqueue:blob:" + key
+}
+
+// SpillIfTooLarge moves body to store under a new key and returns a
+// reference to put in Body instead, when body exceeds maxBytes.
+// maxBytes <= 0 disables spilling and body is returned unchanged.
+func SpillIfTooLarge(ctx context.Context, store BlobStore, body []byte, maxBytes int) ([]byte, error) {
+	if maxBytes <= 0 || len(body) <= maxBytes || store == nil {
+		return body, nil
+	}
+
+	key := uuid.New().String()
+	if err := store.Put(ctx, key, body); err != nil {
+		return nil, err
+	}
+	return []byte(blobRefPrefix + key), nil
+}
+
+// RestoreIfSpilled reverses SpillIfTooLarge: if body is a reference
+// written by it, the original payload is fetched from store and the
+// blob is deleted, so a spilled payload doesn't outlive the message
+// that reserved it.
+func RestoreIfSpilled(ctx context.Context, store BlobStore, body []byte) ([]byte, error) {
+	ref, ok := blobRef(body)
+	if !ok {
+		return body, nil
+	}
+	if store == nil {
+		return nil, fmt.Errorf("msgqueue: message body is a blob reference but no BlobStore is configured")
+	}
+
+	restored, err := store.Get(ctx, ref)
+	if err != nil {
+		return nil, err
+	}
+	if derr := store.Delete(ctx, ref); derr != nil {
+		internal.Logf("msgqueue: deleting spilled blob %s failed: %s", ref, derr)
+	}
+	return restored, nil
+}
+
+func blobRef(body []byte) (string, bool) {
+	if len(body) <= len(blobRefPrefix) || string(body[:len(blobRefPrefix)]) != blobRefPrefix {
+		return "", false
+	}
+	return string(body[len(blobRefPrefix):]), true
+}
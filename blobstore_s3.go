@@ -0,0 +1,55 @@
+package msgqueue
+
+import (
+	"bytes"
+	"context"
+	"io/ioutil"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/s3"
+	"github.com/aws/aws-sdk-go/service/s3/s3iface"
+)
+
+// S3BlobStore is a BlobStore for deployments already using S3, so a
+// spilled payload lands next to everything else instead of growing the
+// Redis instance the rest of msgqueue depends on.
+type S3BlobStore struct {
+	s3     s3iface.S3API
+	bucket string
+	prefix string
+}
+
+var _ BlobStore = (*S3BlobStore)(nil)
+
+func NewS3BlobStore(api s3iface.S3API, bucket, prefix string) *S3BlobStore {
+	return &S3BlobStore{s3: api, bucket: bucket, prefix: prefix}
+}
+
+func (s *S3BlobStore) Put(ctx context.Context, key string, body []byte) error {
+	_, err := s.s3.PutObjectWithContext(ctx, &s3.PutObjectInput{
+		Bucket: aws.String(s.bucket),
+		Key:    aws.String(s.prefix + key),
+		Body:   bytes.NewReader(body),
+	})
+	return err
+}
+
+func (s *S3BlobStore) Get(ctx context.Context, key string) ([]byte, error) {
+	out, err := s.s3.GetObjectWithContext(ctx, &s3.GetObjectInput{
+		Bucket: aws.String(s.bucket),
+		Key:    aws.String(s.prefix + key),
+	})
+	if err != nil {
+		return nil, err
+	}
+	defer out.Body.Close()
+	return ioutil.ReadAll(out.Body)
+}
+
+func (s *S3BlobStore) Delete(ctx context.Context, key string) error {
+	_, err := s.s3.DeleteObjectWithContext(ctx, &s3.DeleteObjectInput{
+		Bucket: aws.String(s.bucket),
+		Key:    aws.String(s.prefix + key),
+	})
+	return err
+}
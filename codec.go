@@ -0,0 +1,119 @@
+package msgqueue
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+
+	"github.com/vmihailenco/msgpack/v5"
+)
+
+// Codec marshals and unmarshals a message's args so they can travel as
+// Message.Body. Queues that support configurable wire formats (currently
+// ironmq and sqs) read Options.Codec before falling back to
+// MsgpackCodec, so a fleet can switch formats incrementally: producers
+// on the new codec and consumers still running the old binary keep
+// working as long as the processor tries every codec in
+// Options.FallbackCodecs via UnmarshalWithCodecs.
+//
+// Unmarshal is called with one addressable pointer per arg, already
+// typed to match the Handler parameter it will be passed as - the same
+// convention ProtobufCodec needs for its typed proto.Message
+// destinations, so JSONCodec and MsgpackCodec follow it too rather than
+// decoding into a fresh []interface{} and discarding the caller's types.
+type Codec interface {
+	// Name identifies the codec, e.g. for metrics and debugging.
+	Name() string
+	Marshal(args []interface{}) ([]byte, error)
+	Unmarshal(b []byte, args []interface{}) error
+}
+
+// JSONCodec encodes args as a JSON array.
+type JSONCodec struct{}
+
+var _ Codec = JSONCodec{}
+
+func (JSONCodec) Name() string {
+	return "json"
+}
+
+func (JSONCodec) Marshal(args []interface{}) ([]byte, error) {
+	return json.Marshal(args)
+}
+
+func (JSONCodec) Unmarshal(b []byte, args []interface{}) error {
+	var raw []json.RawMessage
+	if err := json.Unmarshal(b, &raw); err != nil {
+		return err
+	}
+	if len(raw) != len(args) {
+		return fmt.Errorf("msgqueue: JSONCodec: got %d args, want %d", len(raw), len(args))
+	}
+	for i, r := range raw {
+		if err := json.Unmarshal(r, args[i]); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// MsgpackCodec encodes args using msgpack, which is typically 20-30%
+// smaller than JSON for numeric-heavy payloads and cheaper to decode.
+// It is the wire format msgqueue has always used, so it is
+// defaultCodec: switching a deployment to JSONCodec or ProtobufCodec is
+// an opt-in via Options.Codec, not a silent default change.
+type MsgpackCodec struct{}
+
+var _ Codec = MsgpackCodec{}
+
+func (MsgpackCodec) Name() string {
+	return "msgpack"
+}
+
+func (MsgpackCodec) Marshal(args []interface{}) ([]byte, error) {
+	return msgpack.Marshal(args)
+}
+
+func (MsgpackCodec) Unmarshal(b []byte, args []interface{}) error {
+	dec := msgpack.NewDecoder(bytes.NewReader(b))
+	n, err := dec.DecodeArrayLen()
+	if err != nil {
+		return err
+	}
+	if n != len(args) {
+		return fmt.Errorf("msgqueue: MsgpackCodec: got %d args, want %d", n, len(args))
+	}
+	for _, arg := range args {
+		if err := dec.Decode(arg); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// defaultCodec is used by queues whose Options.Codec is unset, keeping
+// the wire format unchanged for existing deployments.
+var defaultCodec Codec = MsgpackCodec{}
+
+// UnmarshalWithCodecs tries each codec in order and returns the first
+// successful decode. It exists so a fleet can migrate from one codec to
+// another without a synchronized deploy: a consumer lists the new codec
+// first and the old one as Options.FallbackCodecs, and processor.Processor
+// keeps accepting both until every producer has switched over.
+func UnmarshalWithCodecs(codecs []Codec, b []byte, args []interface{}) error {
+	if len(codecs) == 0 {
+		return defaultCodec.Unmarshal(b, args)
+	}
+
+	var firstErr error
+	for _, c := range codecs {
+		if err := c.Unmarshal(b, args); err != nil {
+			if firstErr == nil {
+				firstErr = err
+			}
+			continue
+		}
+		return nil
+	}
+	return fmt.Errorf("msgqueue: no codec could decode message body: %w", firstErr)
+}
@@ -0,0 +1,96 @@
+package msgqueue
+
+import (
+	"fmt"
+
+	"github.com/golang/snappy"
+	"github.com/klauspost/compress/s2"
+	"github.com/klauspost/compress/zstd"
+)
+
+// Compression selects how Message.Body is compressed. It replaces the
+// old Options.Compress bool so IronMQ/SQS users who are bumping against
+// broker size limits can pick the ratio/CPU tradeoff that fits, instead
+// of only being able to turn compression on or off.
+type Compression byte
+
+const (
+	CompressionNone Compression = iota
+	CompressionSnappy
+	CompressionZstd
+	CompressionS2
+)
+
+func (c Compression) String() string {
+	switch c {
+	case CompressionNone:
+		return "none"
+	case CompressionSnappy:
+		return "snappy"
+	case CompressionZstd:
+		return "zstd"
+	case CompressionS2:
+		return "s2"
+	default:
+		return fmt.Sprintf("Compression(%d)", byte(c))
+	}
+}
+
+// defaultCompressThreshold is the smallest Body, in bytes, worth
+// spending CPU to compress. Below it the framing byte alone can make
+// the payload bigger.
+const defaultCompressThreshold = 256
+
+var zstdEncoder, _ = zstd.NewWriter(nil)
+var zstdDecoder, _ = zstd.NewReader(nil)
+
+// CompressBody prepends a one-byte codec tag to body and compresses it
+// with the given Compression, unless body is smaller than threshold, in
+// which case it is tagged CompressionNone and left untouched. The tag
+// lets DecompressBody, which ironmq and sqs call when reserving a
+// message, keep reading messages written under an older Compression
+// after Options.Compression changes.
+func CompressBody(body []byte, c Compression, threshold int) ([]byte, error) {
+	if threshold <= 0 {
+		threshold = defaultCompressThreshold
+	}
+	if len(body) < threshold {
+		c = CompressionNone
+	}
+
+	switch c {
+	case CompressionNone:
+		return append([]byte{byte(CompressionNone)}, body...), nil
+	case CompressionSnappy:
+		return append([]byte{byte(CompressionSnappy)}, snappy.Encode(nil, body)...), nil
+	case CompressionS2:
+		return append([]byte{byte(CompressionS2)}, s2.Encode(nil, body)...), nil
+	case CompressionZstd:
+		return zstdEncoder.EncodeAll(body, []byte{byte(CompressionZstd)}), nil
+	default:
+		return nil, fmt.Errorf("msgqueue: unknown Compression %d", c)
+	}
+}
+
+// DecompressBody reverses CompressBody, reading the codec tag off the
+// front of b to decide how to decode the rest.
+func DecompressBody(b []byte) ([]byte, error) {
+	if len(b) == 0 {
+		return nil, nil
+	}
+
+	tag, body := Compression(b[0]), b[1:]
+	switch tag {
+	case CompressionNone:
+		return body, nil
+	case CompressionSnappy:
+		return snappy.Decode(nil, body)
+	case CompressionS2:
+		return s2.Decode(nil, body)
+	case CompressionZstd:
+		return zstdDecoder.DecodeAll(body, nil)
+	default:
+		return nil, fmt.Errorf("msgqueue: unknown Compression tag %d", tag)
+	}
+}
+
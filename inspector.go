@@ -0,0 +1,177 @@
+package msgqueue
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"time"
+
+	"github.com/go-redis/redis/v8"
+)
+
+// cancelTTL bounds how long a CancelTask marker lingers in Redis; a
+// Handler is expected to notice and exit well before this.
+const cancelTTL = 24 * time.Hour
+
+// Inspector gives operators a live view of queues across a fleet,
+// backed by the JSON heartbeat values processor.Heartbeater writes under
+// msgqueue:servers:* (plain string keys, not Redis hashes), without
+// depending on a broker-specific dashboard like the IronMQ web console.
+type Inspector struct {
+	rdb redis.Cmdable
+}
+
+func NewInspector(rdb redis.Cmdable) *Inspector {
+	return &Inspector{rdb: rdb}
+}
+
+// ServerInfo is one entry of Inspector.Servers, decoded from the JSON
+// of a processor.Heartbeat.
+type ServerInfo struct {
+	Host         string   `json:"host"`
+	PID          int      `json:"pid"`
+	ServerID     string   `json:"server_id"`
+	Concurrency  int      `json:"concurrency"`
+	Queues       []string `json:"queues"`
+	ActiveMsgIDs []string `json:"active_msg_ids"`
+}
+
+// Servers lists every server with a live heartbeat, i.e. every process
+// whose heartbeat hasn't expired.
+func (in *Inspector) Servers(ctx context.Context) ([]*ServerInfo, error) {
+	keys, err := in.scanKeys(ctx, "msgqueue:servers:*")
+	if err != nil {
+		return nil, err
+	}
+
+	servers := make([]*ServerInfo, 0, len(keys))
+	for _, key := range keys {
+		b, err := in.rdb.Get(ctx, key).Bytes()
+		if err == redis.Nil {
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+
+		var info ServerInfo
+		if err := json.Unmarshal(b, &info); err != nil {
+			return nil, err
+		}
+		servers = append(servers, &info)
+	}
+	return servers, nil
+}
+
+// ActiveTasks returns the message ids every live server reports as
+// currently in flight for qname.
+func (in *Inspector) ActiveTasks(ctx context.Context, qname string) ([]string, error) {
+	servers, err := in.Servers(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	var ids []string
+	for _, s := range servers {
+		if !containsString(s.Queues, qname) {
+			continue
+		}
+		ids = append(ids, s.ActiveMsgIDs...)
+	}
+	return ids, nil
+}
+
+// PendingTasks returns how many messages are waiting in q, using
+// Queue.Len. It does not list their ids; broker-specific listing (IronMQ
+// and SQS don't expose a cheap "peek all" operation) is left to the caller.
+func (in *Inspector) PendingTasks(ctx context.Context, q Queue) (int, error) {
+	return q.Len()
+}
+
+// CancelTask marks id as cancelled. processor.Processor checks this
+// itself right before invoking Options.Handler, so a message cancelled
+// while still queued is dropped without ever running. It cannot reach
+// into a Handler already invoked for id - a long-running Handler has to
+// poll IsTaskCancelled(ctx, id) itself to stop early. The cancel marker
+// expires on its own.
+func (in *Inspector) CancelTask(ctx context.Context, id string) error {
+	return in.rdb.Set(ctx, cancelKey(id), 1, cancelTTL).Err()
+}
+
+// IsTaskCancelled reports whether CancelTask was called for id and the
+// marker hasn't expired yet.
+func (in *Inspector) IsTaskCancelled(ctx context.Context, id string) (bool, error) {
+	err := in.rdb.Get(ctx, cancelKey(id)).Err()
+	if err == redis.Nil {
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+	return true, nil
+}
+
+// PauseQueue tells every Processor polling qname to stop reserving new
+// messages, without stopping the process. Processor checks this between
+// reservations via IsQueuePaused.
+func (in *Inspector) PauseQueue(ctx context.Context, qname string) error {
+	return in.rdb.Set(ctx, pauseKey(qname), 1, 0).Err()
+}
+
+// UnpauseQueue reverses PauseQueue.
+func (in *Inspector) UnpauseQueue(ctx context.Context, qname string) error {
+	err := in.rdb.Del(ctx, pauseKey(qname)).Err()
+	if err != nil && err != redis.Nil {
+		return err
+	}
+	return nil
+}
+
+// IsQueuePaused reports whether PauseQueue(qname) is in effect. A
+// Processor calls this before every reservation.
+func (in *Inspector) IsQueuePaused(ctx context.Context, qname string) (bool, error) {
+	n, err := in.rdb.Exists(ctx, pauseKey(qname)).Result()
+	if err != nil {
+		return false, err
+	}
+	return n > 0, nil
+}
+
+func (in *Inspector) scanKeys(ctx context.Context, pattern string) ([]string, error) {
+	var (
+		keys   []string
+		cursor uint64
+	)
+	for {
+		var (
+			batch []string
+			err   error
+		)
+		batch, cursor, err = in.rdb.Scan(ctx, cursor, pattern, 100).Result()
+		if err != nil {
+			return nil, err
+		}
+		keys = append(keys, batch...)
+		if cursor == 0 {
+			break
+		}
+	}
+	return keys, nil
+}
+
+func cancelKey(id string) string {
+	return fmt.Sprintf("msgqueue:cancel:%s", id)
+}
+
+func pauseKey(qname string) string {
+	return fmt.Sprintf("msgqueue:paused:%s", qname)
+}
+
+func containsString(ss []string, s string) bool {
+	for _, v := range ss {
+		if v == s {
+			return true
+		}
+	}
+	return false
+}
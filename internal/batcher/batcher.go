@@ -0,0 +1,174 @@
+// Package batcher holds the broker-agnostic pieces of batch
+// reservation/deletion: splitting a growing slice of messages into
+// broker-sized chunks and retrying a flush when the broker fails with a
+// transient error. ironmq and sqs both build their batchers on top of
+// this instead of repeating the same bookkeeping.
+package batcher
+
+import (
+	"sync"
+	"time"
+
+	"github.com/go-msgqueue/msgqueue"
+)
+
+// Splitter divides pending messages into a batch ready to flush and the
+// remainder still waiting to fill the next one.
+type Splitter func(msgs []*msgqueue.Message) (batch, rest []*msgqueue.Message)
+
+// SizeSplitter returns a Splitter that flushes once len(msgs) reaches
+// size, matching brokers with a fixed batch limit such as SQS's
+// 10-message DeleteMessageBatch or IronMQ's own batch endpoints.
+func SizeSplitter(size int) Splitter {
+	return func(msgs []*msgqueue.Message) (batch, rest []*msgqueue.Message) {
+		if len(msgs) >= size {
+			return msgs, nil
+		}
+		return nil, msgs
+	}
+}
+
+// Options configures a Batcher.
+type Options struct {
+	// Handler flushes one batch. It is called synchronously from Add
+	// whenever Splitter produces a non-empty batch.
+	Handler func(msgs []*msgqueue.Message) error
+	// Splitter decides when a batch is ready to flush. Defaults to
+	// SizeSplitter(100).
+	Splitter Splitter
+
+	// MaxSize is an upper bound on how many messages Add buffers
+	// before forcing a flush, independent of Splitter. Zero means no
+	// extra bound beyond what Splitter enforces.
+	MaxSize int
+	// Timeout, when set, makes New start a ticker that calls Flush every
+	// Timeout, so a partial batch is not held longer than that. Zero
+	// means no timer; a partial batch then waits for Splitter, MaxSize
+	// or an explicit Flush.
+	Timeout time.Duration
+
+	// RetryLimit bounds how many attempts Handler gets for one batch
+	// while ShouldRetry(err) is true. Defaults to 3.
+	RetryLimit int
+	// ShouldRetry decides whether a Handler error is worth retrying,
+	// e.g. a 5xx from the broker. Defaults to never retrying.
+	ShouldRetry func(error) bool
+}
+
+func (opt *Options) init() {
+	if opt.Splitter == nil {
+		opt.Splitter = SizeSplitter(100)
+	}
+	if opt.RetryLimit == 0 {
+		opt.RetryLimit = 3
+	}
+	if opt.ShouldRetry == nil {
+		opt.ShouldRetry = func(error) bool { return false }
+	}
+}
+
+// Batcher buffers messages from many concurrent Add callers and flushes
+// them to Handler in batches, retrying failed flushes according to
+// ShouldRetry. When Options.Timeout is set, a partial batch that never
+// reaches Splitter's threshold is flushed on its own once Timeout has
+// elapsed, so low-volume traffic doesn't sit buffered indefinitely.
+type Batcher struct {
+	opt *Options
+
+	mu   sync.Mutex
+	msgs []*msgqueue.Message
+
+	stopOnce sync.Once
+	stopCh   chan struct{}
+	done     chan struct{}
+}
+
+func New(opt *Options) *Batcher {
+	opt.init()
+
+	b := &Batcher{
+		opt:    opt,
+		stopCh: make(chan struct{}),
+		done:   make(chan struct{}),
+	}
+	if opt.Timeout > 0 {
+		go b.timeoutLoop()
+	} else {
+		close(b.done)
+	}
+	return b
+}
+
+func (b *Batcher) timeoutLoop() {
+	defer close(b.done)
+
+	ticker := time.NewTicker(b.opt.Timeout)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+			_ = b.Flush()
+		case <-b.stopCh:
+			return
+		}
+	}
+}
+
+// Close stops the background Timeout flush loop, if one was started. It
+// does not flush whatever is currently buffered; call Flush first.
+func (b *Batcher) Close() error {
+	b.stopOnce.Do(func() { close(b.stopCh) })
+	<-b.done
+	return nil
+}
+
+// Add buffers msg and flushes a batch through Handler whenever Splitter
+// (or MaxSize) says one is ready.
+func (b *Batcher) Add(msg *msgqueue.Message) error {
+	b.mu.Lock()
+	b.msgs = append(b.msgs, msg)
+	batch, rest := b.opt.Splitter(b.msgs)
+	if b.opt.MaxSize > 0 && len(batch) == 0 && len(rest) >= b.opt.MaxSize {
+		batch, rest = rest, nil
+	}
+	b.msgs = rest
+	b.mu.Unlock()
+
+	if len(batch) == 0 {
+		return nil
+	}
+	return b.flush(batch)
+}
+
+// Flush forces out whatever is currently buffered, e.g. on shutdown.
+func (b *Batcher) Flush() error {
+	b.mu.Lock()
+	batch := b.msgs
+	b.msgs = nil
+	b.mu.Unlock()
+
+	if len(batch) == 0 {
+		return nil
+	}
+	return b.flush(batch)
+}
+
+func (b *Batcher) flush(batch []*msgqueue.Message) error {
+	return Retry(b.opt.RetryLimit, b.opt.ShouldRetry, func() error {
+		return b.opt.Handler(batch)
+	})
+}
+
+// Retry calls fn until it succeeds, shouldRetry(err) returns false, or
+// limit attempts have been made.
+func Retry(limit int, shouldRetry func(error) bool, fn func() error) error {
+	var err error
+	for i := 0; i < limit; i++ {
+		err = fn()
+		if err == nil || !shouldRetry(err) {
+			return err
+		}
+	}
+	return err
+}
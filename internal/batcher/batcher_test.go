@@ -0,0 +1,99 @@
+package batcher_test
+
+import (
+	"errors"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/go-msgqueue/msgqueue"
+	"github.com/go-msgqueue/msgqueue/internal/batcher"
+)
+
+func TestBatcherFlushesOnSplit(t *testing.T) {
+	var got []*msgqueue.Message
+	b := batcher.New(&batcher.Options{
+		Handler: func(msgs []*msgqueue.Message) error {
+			got = msgs
+			return nil
+		},
+		Splitter: batcher.SizeSplitter(2),
+	})
+
+	if err := b.Add(msgqueue.NewMessage()); err != nil {
+		t.Fatal(err)
+	}
+	if got != nil {
+		t.Fatalf("handler fired before batch was full")
+	}
+
+	if err := b.Add(msgqueue.NewMessage()); err != nil {
+		t.Fatal(err)
+	}
+	if len(got) != 2 {
+		t.Fatalf("got %d messages, wanted 2", len(got))
+	}
+}
+
+func TestRetryStopsOnNonRetryableError(t *testing.T) {
+	var calls int
+	err := batcher.Retry(3, func(error) bool { return false }, func() error {
+		calls++
+		return errors.New("permanent")
+	})
+	if err == nil {
+		t.Fatal("expected error")
+	}
+	if calls != 1 {
+		t.Fatalf("fn called %d times, wanted 1", calls)
+	}
+}
+
+func TestBatcherPropagatesFlushErrorToCaller(t *testing.T) {
+	b := batcher.New(&batcher.Options{
+		Handler: func(msgs []*msgqueue.Message) error {
+			return errors.New("broker rejected the batch")
+		},
+		Splitter: batcher.SizeSplitter(1),
+	})
+
+	if err := b.Add(msgqueue.NewMessage()); err == nil {
+		t.Fatal("expected the batch's error back from Add, got nil")
+	}
+}
+
+func TestBatcherFlushesPartialBatchOnTimeout(t *testing.T) {
+	var got int32
+	b := batcher.New(&batcher.Options{
+		Handler: func(msgs []*msgqueue.Message) error {
+			atomic.AddInt32(&got, int32(len(msgs)))
+			return nil
+		},
+		Splitter: batcher.SizeSplitter(10),
+		Timeout:  20 * time.Millisecond,
+	})
+	defer b.Close()
+
+	if err := b.Add(msgqueue.NewMessage()); err != nil {
+		t.Fatal(err)
+	}
+
+	time.Sleep(100 * time.Millisecond)
+	if n := atomic.LoadInt32(&got); n != 1 {
+		t.Fatalf("timeout flushed %d messages, wanted 1", n)
+	}
+}
+
+func TestRetryRetriesUntilLimit(t *testing.T) {
+	var calls int
+	err := batcher.Retry(3, func(error) bool { return true }, func() error {
+		calls++
+		return errors.New("transient")
+	})
+	if err == nil {
+		t.Fatal("expected error")
+	}
+	if calls != 3 {
+		t.Fatalf("fn called %d times, wanted 3", calls)
+	}
+}
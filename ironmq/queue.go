@@ -1,6 +1,7 @@
 package ironmq
 
 import (
+	"context"
 	"errors"
 	"fmt"
 	"strings"
@@ -12,6 +13,7 @@ import (
 
 	"github.com/go-msgqueue/msgqueue"
 	"github.com/go-msgqueue/msgqueue/internal"
+	"github.com/go-msgqueue/msgqueue/internal/batcher"
 	"github.com/go-msgqueue/msgqueue/internal/msgutil"
 	"github.com/go-msgqueue/msgqueue/memqueue"
 )
@@ -106,7 +108,7 @@ func (q *Queue) initDelQueue() {
 	})
 	q.delBatcher = msgqueue.NewBatcher(q.delQueue.Processor(), &msgqueue.BatcherOptions{
 		Handler:  q.deleteBatch,
-		Splitter: q.splitDeleteBatch,
+		Splitter: splitDeleteBatch,
 	})
 }
 
@@ -153,14 +155,89 @@ func (q *Queue) createQueue() error {
 
 // Add adds message to the queue.
 func (q *Queue) Add(msg *msgqueue.Message) error {
-	_, err := msg.EncodeArgs()
+	return q.addWithDedupTTL(msg, q.opt.DedupTTL)
+}
+
+// addWithDedupTTL encodes and enqueues msg, locking msg.Name for
+// dedupTTL first when msg.Name is set. dedupTTL falling back to
+// q.opt.DedupTTL lets CallOnce derive the lock's lifetime from its own
+// period instead of always using the queue-wide default.
+func (q *Queue) addWithDedupTTL(msg *msgqueue.Message, dedupTTL time.Duration) error {
+	ctx, span := msgqueue.StartSpan(context.Background(), q.opt, "add", msg)
+	defer span.End()
+
+	_, err := msg.EncodeArgs(q.codec())
 	if err != nil {
 		return err
 	}
 
-	msg.Compress = q.Options().Compress
-	msg = msgutil.WrapMessage(msg)
-	return q.addQueue.Add(msg)
+	locked := false
+	if msg.Name != "" && q.opt.NameLocker != nil {
+		if dedupTTL <= 0 {
+			dedupTTL = q.opt.DedupTTL
+		}
+		if dedupTTL <= 0 {
+			return fmt.Errorf("ironmq: message name %q needs a positive dedup TTL: set Options.DedupTTL or call CallOnce with a period > 0", msg.Name)
+		}
+
+		ok, err := q.opt.NameLocker.Lock(ctx, msg.Name, msg.Id, dedupTTL)
+		if err != nil {
+			return err
+		}
+		if !ok {
+			return msgqueue.ErrDuplicate
+		}
+		locked = true
+	}
+
+	wrapped := msgutil.WrapMessage(msg)
+	if err := q.addQueue.Add(wrapped); err != nil {
+		if locked {
+			_ = q.opt.NameLocker.Unlock(context.Background(), msg.Name)
+		}
+		return err
+	}
+	return nil
+}
+
+// Unlock releases a name previously locked by CallOnce/Add, e.g. once
+// the handler has processed it successfully, so the name can be reused
+// before its lock expires (Options.DedupTTL for Add, the period for
+// CallOnce).
+func (q *Queue) Unlock(name string) error {
+	if q.opt.NameLocker == nil {
+		return nil
+	}
+	return q.opt.NameLocker.Unlock(context.Background(), name)
+}
+
+// codec returns the codec configured for this queue, falling back to
+// msgpack so existing deployments keep their current wire format.
+func (q *Queue) codec() msgqueue.Codec {
+	if q.opt.Codec != nil {
+		return q.opt.Codec
+	}
+	return msgqueue.MsgpackCodec{}
+}
+
+// packBody compresses body per Options.Compression/CompressThreshold
+// and, once it's still bigger than Options.MaxPayloadBytes, spills it
+// to Options.BlobStore so it fits under IronMQ's own message size
+// limit. ReserveN reverses both steps via unpackBody.
+func (q *Queue) packBody(ctx context.Context, body []byte) ([]byte, error) {
+	body, err := msgqueue.CompressBody(body, q.opt.Compression, q.opt.CompressThreshold)
+	if err != nil {
+		return nil, err
+	}
+	return msgqueue.SpillIfTooLarge(ctx, q.opt.BlobStore, body, q.opt.MaxPayloadBytes)
+}
+
+func (q *Queue) unpackBody(ctx context.Context, body []byte) ([]byte, error) {
+	body, err := msgqueue.RestoreIfSpilled(ctx, q.opt.BlobStore, body)
+	if err != nil {
+		return nil, err
+	}
+	return msgqueue.DecompressBody(body)
 }
 
 // Call creates a message using the args and adds it to the queue.
@@ -170,14 +247,19 @@ func (q *Queue) Call(args ...interface{}) error {
 }
 
 // CallOnce works like Call, but it adds message with same args
-// only once in a period.
+// only once in a period; the dedup lock is held for that same period,
+// not Options.DedupTTL, so the window CallOnce promises actually matches
+// the one it locks.
 func (q *Queue) CallOnce(period time.Duration, args ...interface{}) error {
 	msg := msgqueue.NewMessage(args...)
 	msg.SetDelayName(period, args...)
-	return q.Add(msg)
+	return q.addWithDedupTTL(msg, period)
 }
 
 func (q *Queue) ReserveN(n int) ([]*msgqueue.Message, error) {
+	_, span := msgqueue.StartSpan(context.Background(), q.opt, "reserve", nil)
+	defer span.End()
+
 	if n > 100 {
 		n = 100
 	}
@@ -200,34 +282,109 @@ func (q *Queue) ReserveN(n int) ([]*msgqueue.Message, error) {
 
 	msgs := make([]*msgqueue.Message, len(mqMsgs))
 	for i, mqMsg := range mqMsgs {
+		body, err := q.unpackBody(context.Background(), mqMsg.Body)
+		if err != nil {
+			return nil, err
+		}
+
 		msgs[i] = &msgqueue.Message{
 			Id:   mqMsg.Id,
-			Body: mqMsg.Body,
+			Body: body,
 
 			ReservationId: mqMsg.ReservationId,
 			ReservedCount: mqMsg.ReservedCount,
+
+			// Decode with the queue's configured codec first so a fleet
+			// can migrate from JSON to a binary format incrementally;
+			// DecodeArgs falls back to opt.FallbackCodecs for messages
+			// still produced by the old codec.
+			Codec: q.codec(),
 		}
 	}
 	return msgs, nil
 }
 
 func (q *Queue) Release(msg *msgqueue.Message) error {
+	_, span := msgqueue.StartSpan(context.Background(), q.opt, "release", msg)
+	defer span.End()
+
 	return retry(func() error {
 		return q.q.ReleaseMessage(msg.Id, msg.ReservationId, int64(msg.Delay/time.Second))
 	})
 }
 
 func (q *Queue) Delete(msg *msgqueue.Message) error {
+	_, span := msgqueue.StartSpan(context.Background(), q.opt, "delete", msg)
+	defer span.End()
+
 	err := retry(func() error {
 		return q.q.DeleteMessage(msg.Id, msg.ReservationId)
 	})
-	if err == nil {
-		return nil
+	if err != nil {
+		if v, ok := err.(api.HTTPResponseError); ok && v.StatusCode() == 404 {
+			return nil
+		}
+		return err
 	}
-	if v, ok := err.(api.HTTPResponseError); ok && v.StatusCode() == 404 {
-		return nil
+
+	if q.opt.Retention > 0 {
+		if rerr := q.saveRetainedResult(msg); rerr != nil {
+			internal.Logf("ironmq: saving retained result for %s failed: %s", msg.Id, rerr)
+		}
 	}
-	return err
+	return nil
+}
+
+// saveRetainedResult moves a compact record of msg into Redis with a TTL
+// of Options.Retention, so GetTaskInfo can answer queries about jobs
+// that have already been deleted from the broker. A Handler may already
+// have stored the real result via ResultWriter.Write under the same
+// key, so this only fills in Result/LastErr when nothing has been
+// written yet, instead of overwriting it with msg's zero values. Delete
+// runs this on both the success and the exhausted-retry fallback path
+// (see processor.Processor.deleteMessage), so a message carrying a
+// LastErr is recorded as TaskStateFailed rather than completed.
+func (q *Queue) saveRetainedResult(msg *msgqueue.Message) error {
+	if q.opt.Redis == nil {
+		return errors.New("ironmq: Options.Retention requires Options.Redis")
+	}
+
+	ctx := context.Background()
+	result, lastErr := msg.Result, msg.Err
+	if existing, err := msgqueue.LoadTaskInfo(ctx, q.opt.Redis, q.Name(), msg.Id); err == nil {
+		if result == nil {
+			result = existing.Result
+		}
+		if lastErr == "" {
+			lastErr = existing.LastErr
+		}
+	}
+
+	state := msgqueue.TaskStateCompleted
+	if lastErr != "" {
+		state = msgqueue.TaskStateFailed
+	}
+
+	info := &msgqueue.TaskInfo{
+		ID:          msg.Id,
+		State:       state,
+		CompletedAt: time.Now(),
+		Result:      result,
+		Retention:   q.opt.Retention,
+		LastErr:     lastErr,
+		Retried:     msg.ReservedCount,
+	}
+	return msgqueue.SaveTaskInfo(ctx, q.opt.Redis, q.Name(), info)
+}
+
+// GetTaskInfo returns the retained result of a message previously
+// processed with Options.Retention set, or an error once the
+// retention TTL has expired.
+func (q *Queue) GetTaskInfo(id string) (*msgqueue.TaskInfo, error) {
+	if q.opt.Redis == nil {
+		return nil, errors.New("ironmq: GetTaskInfo requires Options.Redis")
+	}
+	return msgqueue.LoadTaskInfo(context.Background(), q.opt.Redis, q.Name(), id)
 }
 
 func (q *Queue) Purge() error {
@@ -263,7 +420,12 @@ func (q *Queue) add(msg *msgqueue.Message) error {
 		return err
 	}
 
-	body, err := msg.EncodeArgs()
+	body, err := msg.EncodeArgs(q.codec())
+	if err != nil {
+		return err
+	}
+
+	body, err = q.packBody(context.Background(), body)
 	if err != nil {
 		return err
 	}
@@ -313,26 +475,16 @@ func (q *Queue) deleteBatch(msgs []*msgqueue.Message) error {
 	return nil
 }
 
-func (q *Queue) splitDeleteBatch(msgs []*msgqueue.Message) ([]*msgqueue.Message, []*msgqueue.Message) {
-	const messagesLimit = 10
+// messagesLimit is IronMQ's own batch delete limit.
+const messagesLimit = 10
 
-	if len(msgs) >= messagesLimit {
-		return msgs, nil
-	}
-	return nil, msgs
-}
+var splitDeleteBatch = batcher.SizeSplitter(messagesLimit)
 
 func retry(fn func() error) error {
-	var err error
-	for i := 0; i < 3; i++ {
-		err = fn()
-		if err == nil {
-			return nil
-		}
-		if v, ok := err.(api.HTTPResponseError); ok && v.StatusCode() >= 500 {
-			continue
-		}
-		break
-	}
-	return err
+	return batcher.Retry(3, isRetryable5xx, fn)
+}
+
+func isRetryable5xx(err error) bool {
+	v, ok := err.(api.HTTPResponseError)
+	return ok && v.StatusCode() >= 500
 }
@@ -0,0 +1,66 @@
+package msgqueue
+
+import (
+	"context"
+
+	"go.opentelemetry.io/otel/api/metric"
+	"go.opentelemetry.io/otel/label"
+)
+
+// Instruments mirrors the fields of Stats as OpenTelemetry metric
+// instruments, so a queue's numbers show up in whatever backend
+// Options.MeterProvider is wired to, not just in printStats-style logs.
+// processor.Processor owns one and calls RecordStats/RecordQueueLength
+// as it runs.
+type Instruments struct {
+	processed   metric.Int64Counter
+	fails       metric.Int64Counter
+	retries     metric.Int64Counter
+	inFlight    metric.Int64UpDownCounter
+	avgDuration metric.Float64ValueRecorder
+	queueLength metric.Int64ValueRecorder
+}
+
+// NewInstruments returns nil when opt.MeterProvider is unset, keeping
+// instrumentation zero-cost until a caller opts in.
+func NewInstruments(opt *Options) *Instruments {
+	if opt.MeterProvider == nil {
+		return nil
+	}
+
+	meter := opt.MeterProvider.Meter(tracerName)
+	m := metric.Must(meter)
+	return &Instruments{
+		processed:   m.NewInt64Counter("msgqueue.processed"),
+		fails:       m.NewInt64Counter("msgqueue.fails"),
+		retries:     m.NewInt64Counter("msgqueue.retries"),
+		inFlight:    m.NewInt64UpDownCounter("msgqueue.in_flight"),
+		avgDuration: m.NewFloat64ValueRecorder("msgqueue.avg_duration_seconds"),
+		queueLength: m.NewInt64ValueRecorder("msgqueue.queue_length"),
+	}
+}
+
+// RecordStats reports one Stats snapshot, the same fields printStats
+// logs, tagged by queue name. processor.Processor calls it after every
+// message it processes.
+func (in *Instruments) RecordStats(ctx context.Context, queueName string, st *Stats) {
+	if in == nil {
+		return
+	}
+
+	queue := label.String("queue", queueName)
+	in.processed.Add(ctx, int64(st.Processed), queue)
+	in.fails.Add(ctx, int64(st.Fails), queue)
+	in.retries.Add(ctx, int64(st.Retries), queue)
+	in.inFlight.Add(ctx, int64(st.InFlight), queue)
+	in.avgDuration.Record(ctx, st.AvgDuration.Seconds(), queue)
+}
+
+// RecordQueueLength reports Queue.Len(), which a Processor polls
+// separately from the rest of Stats.
+func (in *Instruments) RecordQueueLength(ctx context.Context, queueName string, n int) {
+	if in == nil {
+		return
+	}
+	in.queueLength.Record(ctx, int64(n), label.String("queue", queueName))
+}
@@ -0,0 +1,53 @@
+package msgqueue
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/go-redis/redis/v8"
+)
+
+// ErrDuplicate is returned by ironmq.Queue.Add when msg.Name is set and a
+// message with the same name is already locked, e.g. via CallOnce.
+var ErrDuplicate = errors.New("msgqueue: message with such name already exists")
+
+// NameLocker deduplicates messages by Message.Name across every
+// producer in a fleet, not just within one process. ironmq.Queue.Add
+// calls Lock before enqueueing a named message and returns ErrDuplicate
+// on conflict; ironmq.Queue.Unlock releases the name early, e.g. once
+// the handler succeeds, so CallOnce can fire again before the TTL would
+// otherwise expire. sqs.Queue does not use a NameLocker.
+type NameLocker interface {
+	Lock(ctx context.Context, name, id string, ttl time.Duration) (bool, error)
+	Unlock(ctx context.Context, name string) error
+}
+
+// RedisNameLocker is the default NameLocker, implemented with a
+// SET NX PX so the lock and its expiration are set atomically.
+type RedisNameLocker struct {
+	rdb redis.Cmdable
+}
+
+var _ NameLocker = (*RedisNameLocker)(nil)
+
+func NewRedisNameLocker(rdb redis.Cmdable) *RedisNameLocker {
+	return &RedisNameLocker{rdb: rdb}
+}
+
+func (l *RedisNameLocker) Lock(ctx context.Context, name, id string, ttl time.Duration) (bool, error) {
+	return l.rdb.SetNX(ctx, nameLockKey(name), id, ttl).Result()
+}
+
+func (l *RedisNameLocker) Unlock(ctx context.Context, name string) error {
+	err := l.rdb.Del(ctx, nameLockKey(name)).Err()
+	if err != nil && err != redis.Nil {
+		return err
+	}
+	return nil
+}
+
+func nameLockKey(name string) string {
+	return fmt.Sprintf("msgqueue:dedup:%s", name)
+}
@@ -0,0 +1,158 @@
+package processor
+
+import (
+	"context"
+	"encoding/json"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/go-redis/redis/v8"
+	"github.com/rs/xid"
+)
+
+// heartbeatTTL is how long a server's heartbeat key survives without a
+// refresh before Inspector.Servers stops reporting it. It must be
+// comfortably larger than the heartbeat interval so a single missed
+// tick doesn't make a healthy process look dead.
+const heartbeatTTL = 30 * time.Second
+
+// Heartbeat is what a Processor writes to Redis every few seconds so an
+// Inspector elsewhere can see it, in the spirit of asynqmon: operators
+// get a live view of every running server without depending on the
+// broker's own dashboard. It is stored as one JSON-encoded string value
+// under msgqueue:servers:<ServerID>, not as a Redis hash with a field per
+// attribute. ServerID lets an operator tell which running
+// process a given Heartbeat came from, e.g. to match it against a
+// deploy's own pod/host name before recycling it; msgqueue itself has
+// no drain-this-serverID call, only PauseQueue for a whole queue name.
+type Heartbeat struct {
+	Host         string    `json:"host"`
+	PID          int       `json:"pid"`
+	ServerID     string    `json:"server_id"`
+	StartedAt    time.Time `json:"started_at"`
+	Concurrency  int       `json:"concurrency"`
+	Queues       []string  `json:"queues"`
+	ActiveMsgIDs []string  `json:"active_msg_ids"`
+}
+
+// Heartbeater periodically writes a Heartbeat to Redis for one
+// Processor. Processor.Start runs it, and Processor.StopTimeout stops it
+// as soon as the workers are told to stop, before in-flight messages
+// have finished; Inspector.Servers keeps listing the last heartbeat
+// written until heartbeatTTL expires.
+type Heartbeater struct {
+	rdb      redis.Cmdable
+	serverID string
+	interval time.Duration
+
+	concurrency int
+	queues      []string
+	startedAt   time.Time
+
+	mu     sync.Mutex
+	active map[string]struct{}
+
+	stop chan struct{}
+	done chan struct{}
+}
+
+// NewHeartbeater returns a Heartbeater identified by a freshly generated
+// xid, reporting queues at concurrency workers every interval.
+func NewHeartbeater(rdb redis.Cmdable, queues []string, concurrency int, interval time.Duration) *Heartbeater {
+	return &Heartbeater{
+		rdb:         rdb,
+		serverID:    xid.New().String(),
+		interval:    interval,
+		concurrency: concurrency,
+		queues:      queues,
+		startedAt:   time.Now(),
+		active:      make(map[string]struct{}),
+		stop:        make(chan struct{}),
+		done:        make(chan struct{}),
+	}
+}
+
+// ServerID identifies this Heartbeater/Processor among ServerInfo.ServerID
+// entries returned by Inspector.Servers.
+func (h *Heartbeater) ServerID() string {
+	return h.serverID
+}
+
+// Track records msgID as in flight, so the next heartbeat reports it
+// under ActiveMsgIDs.
+func (h *Heartbeater) Track(msgID string) {
+	h.mu.Lock()
+	h.active[msgID] = struct{}{}
+	h.mu.Unlock()
+}
+
+// Untrack removes msgID once it has been deleted or released.
+func (h *Heartbeater) Untrack(msgID string) {
+	h.mu.Lock()
+	delete(h.active, msgID)
+	h.mu.Unlock()
+}
+
+// Run writes a heartbeat immediately and then every h.interval, until
+// ctx is done or Stop is called. It is meant to be run in its own
+// goroutine by the owning Processor.
+func (h *Heartbeater) Run(ctx context.Context) {
+	defer close(h.done)
+
+	ticker := time.NewTicker(h.interval)
+	defer ticker.Stop()
+
+	h.beat(ctx)
+	for {
+		select {
+		case <-ticker.C:
+			h.beat(ctx)
+		case <-h.stop:
+			return
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+// Stop stops the heartbeat loop and waits for it to exit.
+func (h *Heartbeater) Stop() {
+	close(h.stop)
+	<-h.done
+}
+
+func (h *Heartbeater) beat(ctx context.Context) {
+	host, _ := os.Hostname()
+
+	h.mu.Lock()
+	active := make([]string, 0, len(h.active))
+	for id := range h.active {
+		active = append(active, id)
+	}
+	h.mu.Unlock()
+
+	hb := &Heartbeat{
+		Host:         host,
+		PID:          os.Getpid(),
+		ServerID:     h.serverID,
+		StartedAt:    h.startedAt,
+		Concurrency:  h.concurrency,
+		Queues:       h.queues,
+		ActiveMsgIDs: active,
+	}
+
+	b, err := json.Marshal(hb)
+	if err != nil {
+		return
+	}
+
+	key := heartbeatKey(h.serverID)
+	pipe := h.rdb.Pipeline()
+	pipe.Set(ctx, key, b, heartbeatTTL)
+	_, _ = pipe.Exec(ctx)
+}
+
+func heartbeatKey(serverID string) string {
+	return "msgqueue:servers:" + serverID
+}
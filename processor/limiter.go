@@ -0,0 +1,58 @@
+package processor
+
+import (
+	"context"
+	"time"
+
+	"github.com/go-redis/redis/v8"
+	"github.com/go-redis/redis_rate/v9"
+)
+
+// Limiter caps how fast a Processor dequeues messages. Unlike
+// golang.org/x/time/rate, which only throttles a single process,
+// implementations are expected to be shared across a Redis instance so
+// WorkerNumber processes spread across many machines still honor one
+// global budget.
+type Limiter interface {
+	// AllowN reports whether n messages from key may be processed now.
+	// When it returns false, retryAfter is how long the caller should
+	// sleep before asking again.
+	AllowN(ctx context.Context, key string, n int) (allow bool, retryAfter time.Duration, err error)
+}
+
+// RateLimiter is the default Limiter, backed by redis_rate's GCRA
+// implementation. A zero value is not usable; use NewRateLimiter.
+type RateLimiter struct {
+	limiter *redis_rate.Limiter
+	limit   redis_rate.Limit
+}
+
+var _ Limiter = (*RateLimiter)(nil)
+
+// NewRateLimiter returns a Limiter that allows up to rps requests per
+// second per key, shared by every process pointed at rdb.
+func NewRateLimiter(rdb redis.Cmdable, rps int) *RateLimiter {
+	return &RateLimiter{
+		limiter: redis_rate.NewLimiter(rdb),
+		limit:   redis_rate.PerSecond(rps),
+	}
+}
+
+func (l *RateLimiter) AllowN(ctx context.Context, key string, n int) (bool, time.Duration, error) {
+	res, err := l.limiter.AllowN(ctx, key, l.limit, n)
+	if err != nil {
+		return false, 0, err
+	}
+	return res.Allowed > 0, res.RetryAfter, nil
+}
+
+// rateLimitKey returns the key a Processor passes to Limiter.AllowN:
+// queueName:rateKey when rateKey is set, queueName otherwise. A Processor
+// spends the queue-wide budget on every reservation and, for a message
+// with a RateKey, checks its per-name budget as well.
+func rateLimitKey(queueName string, rateKey string) string {
+	if rateKey != "" {
+		return queueName + ":" + rateKey
+	}
+	return queueName
+}
@@ -0,0 +1,455 @@
+// Package processor drives the reserve/process/delete loop shared by
+// every msgqueue.Queue implementation. It pulls messages from a Queuer,
+// dispatches them to Options.Handler by reflection (so a Handler can
+// take whatever argument types Queue.Call was given), retries failures
+// with backoff up to Options.RetryLimit, and records the result in
+// msgqueue.Stats.
+package processor
+
+import (
+	"context"
+	"fmt"
+	"reflect"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/go-msgqueue/msgqueue"
+	"github.com/go-msgqueue/msgqueue/internal"
+)
+
+// Queuer is the subset of msgqueue.Queue a Processor needs to drive its
+// loop. Every msgqueue.Queue (ironmq.Queue, sqs.Queue, ...) satisfies it.
+type Queuer interface {
+	Name() string
+	Len() (int, error)
+	Add(msg *msgqueue.Message) error
+	Call(args ...interface{}) error
+	CallOnce(period time.Duration, args ...interface{}) error
+	ReserveN(n int) ([]*msgqueue.Message, error)
+	Release(msg *msgqueue.Message) error
+	Delete(msg *msgqueue.Message) error
+	Purge() error
+}
+
+// Delayer lets a Handler error override the backoff Options.MinBackoff
+// would otherwise compute, e.g. to honor a rate-limit response that
+// names its own retry-after.
+type Delayer interface {
+	Delay() time.Duration
+}
+
+// queueLengthInterval is how often a Processor polls Queuer.Len() to
+// feed Instruments.RecordQueueLength.
+const queueLengthInterval = 10 * time.Second
+
+// heartbeatInterval is how often a Processor refreshes its Heartbeater
+// entry, so msgqueue.Inspector.Servers sees it as live.
+const heartbeatInterval = 5 * time.Second
+
+// pausedPollInterval is how long a worker sleeps between
+// Inspector.IsQueuePaused checks while msgqueue.Inspector.PauseQueue is
+// in effect for this queue.
+const pausedPollInterval = time.Second
+
+// Processor reserves messages from a Queuer, one at a time per worker,
+// and runs them through Options.Handler.
+type Processor struct {
+	q   Queuer
+	opt *msgqueue.Options
+
+	metrics     *msgqueue.Instruments
+	inspector   *msgqueue.Inspector
+	heartbeater *Heartbeater
+
+	stopCh chan struct{}
+	wg     sync.WaitGroup
+
+	inFlight, deleting, processed, fails, retries, throttled int64
+}
+
+// Start creates a Processor for q and immediately begins reserving
+// messages, spread across Options.WorkerNumber goroutines (default 1).
+func Start(q Queuer, opt *msgqueue.Options) *Processor {
+	p := New(q, opt)
+	p.Start()
+	return p
+}
+
+// New creates a Processor without starting it; call Start to begin
+// reserving messages.
+func New(q Queuer, opt *msgqueue.Options) *Processor {
+	if opt.WorkerNumber == 0 {
+		opt.WorkerNumber = 1
+	}
+
+	p := &Processor{
+		q:       q,
+		opt:     opt,
+		metrics: msgqueue.NewInstruments(opt),
+		stopCh:  make(chan struct{}),
+	}
+	if opt.Redis != nil {
+		p.inspector = msgqueue.NewInspector(opt.Redis)
+		p.heartbeater = NewHeartbeater(opt.Redis, []string{q.Name()}, opt.WorkerNumber, heartbeatInterval)
+	}
+	return p
+}
+
+// Start launches the worker goroutines and, when Options.Redis is set,
+// the Heartbeater that makes this Processor visible to
+// msgqueue.Inspector.Servers.
+func (p *Processor) Start() {
+	p.wg.Add(1)
+	go func() {
+		defer p.wg.Done()
+		p.monitorQueueLength()
+	}()
+
+	if p.heartbeater != nil {
+		p.wg.Add(1)
+		go func() {
+			defer p.wg.Done()
+			p.heartbeater.Run(context.Background())
+		}()
+	}
+
+	for i := 0; i < p.opt.WorkerNumber; i++ {
+		p.wg.Add(1)
+		go p.worker()
+	}
+}
+
+// Stop is StopTimeout with no deadline.
+func (p *Processor) Stop() error {
+	return p.StopTimeout(0)
+}
+
+// StopTimeout signals every worker to stop reserving new messages and
+// waits up to timeout for in-flight ones to finish. timeout <= 0 waits
+// indefinitely.
+func (p *Processor) StopTimeout(timeout time.Duration) error {
+	close(p.stopCh)
+	if p.heartbeater != nil {
+		p.heartbeater.Stop()
+	}
+
+	done := make(chan struct{})
+	go func() {
+		p.wg.Wait()
+		close(done)
+	}()
+
+	if timeout <= 0 {
+		<-done
+		return nil
+	}
+
+	select {
+	case <-done:
+		return nil
+	case <-time.After(timeout):
+		return fmt.Errorf("msgqueue: processor %s: workers did not stop within %s", p.q.Name(), timeout)
+	}
+}
+
+func (p *Processor) String() string {
+	return fmt.Sprintf("Processor<%s>", p.q.Name())
+}
+
+// Stats returns a snapshot of the counters this Processor has
+// accumulated since it started.
+func (p *Processor) Stats() *msgqueue.Stats {
+	return &msgqueue.Stats{
+		InFlight:  atomic.LoadInt64(&p.inFlight),
+		Deleting:  atomic.LoadInt64(&p.deleting),
+		Processed: atomic.LoadInt64(&p.processed),
+		Fails:     atomic.LoadInt64(&p.fails),
+		Retries:   atomic.LoadInt64(&p.retries),
+		Throttled: atomic.LoadInt64(&p.throttled),
+	}
+}
+
+func (p *Processor) stopped() bool {
+	select {
+	case <-p.stopCh:
+		return true
+	default:
+		return false
+	}
+}
+
+func (p *Processor) worker() {
+	defer p.wg.Done()
+
+	for !p.stopped() {
+		if p.paused() {
+			time.Sleep(pausedPollInterval)
+			continue
+		}
+
+		p.allow(rateLimitKey(p.q.Name(), ""))
+		if p.stopped() {
+			return
+		}
+
+		msgs, err := p.q.ReserveN(1)
+		if err != nil {
+			time.Sleep(time.Second)
+			continue
+		}
+		if len(msgs) == 0 {
+			time.Sleep(100 * time.Millisecond)
+			continue
+		}
+
+		msg := msgs[0]
+		if msg.RateKey != "" {
+			// The queue-wide budget was already spent above; msg.RateKey
+			// is only known once reserved, so its own budget is checked
+			// here instead. Blocking in place rather than releasing
+			// keeps a throttled message from bumping ReservedCount and
+			// round-tripping the broker on every denial.
+			p.allow(rateLimitKey(p.q.Name(), msg.RateKey))
+			if p.stopped() {
+				if rerr := p.q.Release(msg); rerr != nil {
+					internal.Logf("msgqueue: %s: Release failed: %s", p.q.Name(), rerr)
+				}
+				return
+			}
+		}
+
+		p.process(msg)
+	}
+}
+
+// allow blocks until Options.Limiter grants key, sleeping the limiter's
+// own retry-after between attempts instead of reserving a message and
+// releasing it on denial - so throttling never touches
+// Message.ReservedCount or Options.RetryLimit. A nil Limiter returns
+// immediately; so does an AllowN error, which is logged and then
+// treated as a grant, and a Stop while waiting.
+func (p *Processor) allow(key string) {
+	if p.opt.Limiter == nil {
+		return
+	}
+
+	for {
+		ok, retryAfter, err := p.opt.Limiter.AllowN(context.Background(), key, 1)
+		if err != nil {
+			internal.Logf("msgqueue: %s: Limiter.AllowN failed: %s", p.q.Name(), err)
+			return
+		}
+		if ok {
+			return
+		}
+
+		atomic.AddInt64(&p.throttled, 1)
+		select {
+		case <-time.After(retryAfter):
+		case <-p.stopCh:
+			return
+		}
+	}
+}
+
+// paused reports whether msgqueue.Inspector.PauseQueue is in effect for
+// this queue, so operators can drain a fleet without stopping it.
+func (p *Processor) paused() bool {
+	if p.inspector == nil {
+		return false
+	}
+	paused, err := p.inspector.IsQueuePaused(context.Background(), p.q.Name())
+	if err != nil {
+		return false
+	}
+	return paused
+}
+
+// cancelled reports whether msgqueue.Inspector.CancelTask was called for
+// msg before this reservation got around to it, so a Processor can drop
+// it without ever invoking Options.Handler. It only ever catches a
+// message still sitting in the queue when CancelTask runs - a Handler
+// already invoked has to notice cancellation itself, e.g. by checking
+// Inspector.IsTaskCancelled against ctx.Done() in a long-running loop.
+func (p *Processor) cancelled(ctx context.Context, msg *msgqueue.Message) bool {
+	if p.inspector == nil {
+		return false
+	}
+	cancelled, err := p.inspector.IsTaskCancelled(ctx, msg.Id)
+	if err != nil {
+		return false
+	}
+	return cancelled
+}
+
+func (p *Processor) monitorQueueLength() {
+	ticker := time.NewTicker(queueLengthInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+			if n, err := p.q.Len(); err == nil {
+				p.metrics.RecordQueueLength(context.Background(), p.q.Name(), n)
+			}
+		case <-p.stopCh:
+			return
+		}
+	}
+}
+
+func (p *Processor) process(msg *msgqueue.Message) {
+	atomic.AddInt64(&p.inFlight, 1)
+	defer atomic.AddInt64(&p.inFlight, -1)
+
+	if p.heartbeater != nil {
+		p.heartbeater.Track(msg.Id)
+		defer p.heartbeater.Untrack(msg.Id)
+	}
+
+	ctx, span := msgqueue.StartSpan(context.Background(), p.opt, "process", msg)
+	defer span.End()
+
+	if p.opt.Retention > 0 && p.opt.Redis != nil {
+		rw := msgqueue.NewResultWriter(p.opt.Redis, p.q.Name(), msg.Id, p.opt.Retention, msg.ReservedCount)
+		ctx = msgqueue.ContextWithResultWriter(ctx, rw)
+	}
+
+	if p.cancelled(ctx, msg) {
+		p.metrics.RecordStats(ctx, p.q.Name(), p.Stats())
+		p.deleteMessage(msg)
+		return
+	}
+
+	err := p.invoke(ctx, p.opt.Handler, msg)
+	if err == nil {
+		atomic.AddInt64(&p.processed, 1)
+		p.metrics.RecordStats(ctx, p.q.Name(), p.Stats())
+		p.deleteMessage(msg)
+		return
+	}
+
+	atomic.AddInt64(&p.fails, 1)
+	msg.Err = err.Error()
+	p.metrics.RecordStats(ctx, p.q.Name(), p.Stats())
+
+	retryLimit := p.opt.RetryLimit
+	if retryLimit <= 0 {
+		retryLimit = 1
+	}
+	if msg.ReservedCount >= retryLimit {
+		if ferr := p.invoke(ctx, p.opt.FallbackHandler, msg); ferr != nil {
+			internal.Logf("msgqueue: %s: FallbackHandler failed: %s", p.q.Name(), ferr)
+		}
+		p.deleteMessage(msg)
+		return
+	}
+
+	atomic.AddInt64(&p.retries, 1)
+	msg.Delay = retryBackoff(err, msg.ReservedCount, p.opt.MinBackoff)
+	if rerr := p.q.Release(msg); rerr != nil {
+		internal.Logf("msgqueue: %s: Release failed: %s", p.q.Name(), rerr)
+	}
+}
+
+func (p *Processor) deleteMessage(msg *msgqueue.Message) {
+	atomic.AddInt64(&p.deleting, 1)
+	defer atomic.AddInt64(&p.deleting, -1)
+
+	if err := p.q.Delete(msg); err != nil {
+		internal.Logf("msgqueue: %s: Delete failed: %s", p.q.Name(), err)
+	}
+}
+
+// retryBackoff honors a Delayer error, e.g. a rate-limit response that
+// names its own retry-after, and otherwise doubles min with every
+// reservation so repeated failures back off exponentially.
+func retryBackoff(err error, reservedCount int, min time.Duration) time.Duration {
+	if d, ok := err.(Delayer); ok {
+		return d.Delay()
+	}
+	if min <= 0 {
+		min = time.Second
+	}
+	if reservedCount < 1 {
+		reservedCount = 1
+	}
+	return min * time.Duration(int64(1)<<uint(reservedCount-1))
+}
+
+// invoke decodes msg's args to match handler's parameters and calls it.
+// handler may return zero values, a single error, or any other single
+// value (which is ignored). handler == nil is a no-op success.
+func (p *Processor) invoke(ctx context.Context, handler interface{}, msg *msgqueue.Message) (err error) {
+	if handler == nil {
+		return nil
+	}
+
+	fv := reflect.ValueOf(handler)
+	ft := fv.Type()
+	if ft.Kind() != reflect.Func {
+		return fmt.Errorf("msgqueue: Handler must be a func, got %T", handler)
+	}
+
+	in, err := p.decodeArgs(msg, ft)
+	if err != nil {
+		return err
+	}
+
+	defer func() {
+		if r := recover(); r != nil {
+			err = fmt.Errorf("msgqueue: handler panicked: %v", r)
+		}
+	}()
+
+	out := fv.Call(in)
+	if len(out) == 0 {
+		return nil
+	}
+
+	last := out[len(out)-1]
+	if e, ok := last.Interface().(error); ok {
+		return e
+	}
+	return nil
+}
+
+// decodeArgs allocates one addressable value per handler parameter and
+// fills them from msg.Body, trying msg.Codec (or Options.Codec) first
+// and falling back to Options.FallbackCodecs, so a fleet can read
+// messages written under an older codec while it migrates.
+func (p *Processor) decodeArgs(msg *msgqueue.Message, ft reflect.Type) ([]reflect.Value, error) {
+	n := ft.NumIn()
+	in := make([]reflect.Value, n)
+	if n == 0 {
+		return in, nil
+	}
+
+	ptrs := make([]interface{}, n)
+	for i := 0; i < n; i++ {
+		ptrs[i] = reflect.New(ft.In(i)).Interface()
+	}
+
+	if len(msg.Body) > 0 {
+		codecs := append([]msgqueue.Codec{p.codecFor(msg)}, p.opt.FallbackCodecs...)
+		if err := msgqueue.UnmarshalWithCodecs(codecs, msg.Body, ptrs); err != nil {
+			return nil, err
+		}
+	}
+
+	for i, ptr := range ptrs {
+		in[i] = reflect.ValueOf(ptr).Elem()
+	}
+	return in, nil
+}
+
+func (p *Processor) codecFor(msg *msgqueue.Message) msgqueue.Codec {
+	if msg.Codec != nil {
+		return msg.Codec
+	}
+	if p.opt.Codec != nil {
+		return p.opt.Codec
+	}
+	return msgqueue.MsgpackCodec{}
+}
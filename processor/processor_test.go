@@ -1,6 +1,7 @@
 package processor_test
 
 import (
+	"context"
 	"errors"
 	"log"
 	"runtime"
@@ -10,8 +11,7 @@ import (
 	"testing"
 	"time"
 
-	"github.com/go-redis/redis"
-	"golang.org/x/time/rate"
+	"github.com/go-redis/redis/v8"
 
 	"github.com/go-msgqueue/msgqueue"
 	"github.com/go-msgqueue/msgqueue/processor"
@@ -36,8 +36,8 @@ func printStats(p *processor.Processor) {
 		old = st
 
 		log.Printf(
-			"%s: inFlight=%d deleting=%d processed=%d fails=%d retries=%d avg_dur=%s\n",
-			p, st.InFlight, st.Deleting, st.Processed, st.Fails, st.Retries, st.AvgDuration,
+			"%s: inFlight=%d deleting=%d processed=%d fails=%d retries=%d throttled=%d avg_dur=%s\n",
+			p, st.InFlight, st.Deleting, st.Processed, st.Fails, st.Retries, st.Throttled, st.AvgDuration,
 		)
 	}
 }
@@ -47,7 +47,7 @@ func redisRing() *redis.Ring {
 		Addrs:    map[string]string{"0": ":6379"},
 		PoolSize: 100,
 	})
-	err := ring.FlushDb().Err()
+	err := ring.FlushDB(context.Background()).Err()
 	if err != nil {
 		panic(err)
 	}
@@ -294,7 +294,7 @@ func testRateLimit(t *testing.T, q processor.Queuer) {
 	p := processor.Start(q, &msgqueue.Options{
 		Handler:      handler,
 		WorkerNumber: 2,
-		RateLimit:    rate.Every(time.Second),
+		Limiter:      processor.NewRateLimiter(ring, 1),
 		Redis:        ring,
 	})
 	go printStats(p)
@@ -310,6 +310,44 @@ func testRateLimit(t *testing.T, q processor.Queuer) {
 	}
 }
 
+func testCancel(t *testing.T, q processor.Queuer) {
+	t.Parallel()
+
+	_ = q.Purge()
+	ring := redisRing()
+
+	var called int64
+	handler := func() error {
+		atomic.AddInt64(&called, 1)
+		return nil
+	}
+
+	msg := msgqueue.NewMessage()
+	if err := q.Add(msg); err != nil {
+		t.Fatal(err)
+	}
+
+	inspector := msgqueue.NewInspector(ring)
+	if err := inspector.CancelTask(context.Background(), msg.Id); err != nil {
+		t.Fatal(err)
+	}
+
+	p := processor.Start(q, &msgqueue.Options{
+		Handler: handler,
+		Redis:   ring,
+	})
+
+	time.Sleep(3 * time.Second)
+
+	if err := p.Stop(); err != nil {
+		t.Fatal(err)
+	}
+
+	if n := atomic.LoadInt64(&called); n != 0 {
+		t.Fatalf("handler was called %d times for a cancelled message, wanted 0", n)
+	}
+}
+
 type RateLimitError string
 
 func (e RateLimitError) Error() string {
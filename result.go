@@ -0,0 +1,132 @@
+package msgqueue
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"time"
+
+	"github.com/go-redis/redis/v8"
+)
+
+// TaskState describes where a message is in its lifecycle, as recorded
+// by GetTaskInfo once Options.Retention is set.
+type TaskState string
+
+const (
+	TaskStateActive    TaskState = "active"
+	TaskStateCompleted TaskState = "completed"
+	TaskStateFailed    TaskState = "failed"
+)
+
+// TaskInfo is a snapshot of a completed message, kept around for
+// Options.Retention so a producer can poll for the outcome of what would
+// otherwise be a fire-and-forget job.
+type TaskInfo struct {
+	ID          string
+	State       TaskState
+	CompletedAt time.Time
+	Result      []byte
+	Retention   time.Duration
+	LastErr     string
+	Retried     int
+}
+
+// ResultWriter lets a Handler persist the outcome of the message it is
+// processing. The processor only puts one on a Handler's context when
+// Options.Retention is non-zero and Options.Redis is set; see
+// ResultWriterFromContext.
+type ResultWriter interface {
+	// Write stores result as the task's result, to be read back by a
+	// later GetTaskInfo call. Calling Write more than once overwrites
+	// the previous result.
+	Write(ctx context.Context, result []byte) error
+}
+
+type resultWriterCtxKey struct{}
+
+// ContextWithResultWriter returns a copy of ctx carrying rw, so a
+// Handler can retrieve it with ResultWriterFromContext.
+func ContextWithResultWriter(ctx context.Context, rw ResultWriter) context.Context {
+	return context.WithValue(ctx, resultWriterCtxKey{}, rw)
+}
+
+// ResultWriterFromContext returns the ResultWriter stashed on ctx by the
+// processor, and false if ctx carries none (e.g. Options.Retention is
+// unset).
+func ResultWriterFromContext(ctx context.Context) (ResultWriter, bool) {
+	rw, ok := ctx.Value(resultWriterCtxKey{}).(ResultWriter)
+	return rw, ok
+}
+
+// redisResultWriter is the ResultWriter a Processor hands to a Handler
+// via ContextWithResultWriter when the queue's Options.Retention is set.
+type redisResultWriter struct {
+	rdb       redis.Cmdable
+	queueName string
+	id        string
+	retention time.Duration
+	retried   int
+}
+
+var _ ResultWriter = (*redisResultWriter)(nil)
+
+// NewResultWriter returns the ResultWriter a Processor hands a Handler,
+// via ContextWithResultWriter, for a message retained under
+// Options.Retention.
+func NewResultWriter(rdb redis.Cmdable, queueName, id string, retention time.Duration, retried int) ResultWriter {
+	return &redisResultWriter{
+		rdb:       rdb,
+		queueName: queueName,
+		id:        id,
+		retention: retention,
+		retried:   retried,
+	}
+}
+
+func (w *redisResultWriter) Write(ctx context.Context, result []byte) error {
+	info := TaskInfo{
+		ID:          w.id,
+		State:       TaskStateCompleted,
+		CompletedAt: time.Now(),
+		Result:      result,
+		Retention:   w.retention,
+		Retried:     w.retried,
+	}
+	return SaveTaskInfo(ctx, w.rdb, w.queueName, &info)
+}
+
+// taskInfoKey returns the Redis key a queue's task metadata is stored
+// under, namespaced by queue name so multiple queues can share a Redis
+// instance without colliding.
+func taskInfoKey(queueName, id string) string {
+	return fmt.Sprintf("msgqueue:%s:t:%s", queueName, id)
+}
+
+// SaveTaskInfo persists info under msgqueue:{queueName}:t:<info.ID> with
+// a TTL of info.Retention. Brokers call this from Delete once
+// Options.Retention is set, so GetTaskInfo can answer queries after the
+// message itself has been removed from the queue.
+func SaveTaskInfo(ctx context.Context, rdb redis.Cmdable, queueName string, info *TaskInfo) error {
+	b, err := json.Marshal(info)
+	if err != nil {
+		return err
+	}
+	return rdb.Set(ctx, taskInfoKey(queueName, info.ID), b, info.Retention).Err()
+}
+
+// LoadTaskInfo reads back what SaveTaskInfo wrote, returning
+// redis.Nil once the retention TTL has expired or for an id
+// that was never retained.
+func LoadTaskInfo(ctx context.Context, rdb redis.Cmdable, queueName, id string) (*TaskInfo, error) {
+	b, err := rdb.Get(ctx, taskInfoKey(queueName, id)).Bytes()
+	if err != nil {
+		return nil, err
+	}
+
+	var info TaskInfo
+	if err := json.Unmarshal(b, &info); err != nil {
+		return nil, err
+	}
+	return &info, nil
+}
@@ -0,0 +1,451 @@
+// Package sqs implements msgqueue.Queue and msgqueue.BatchBroker on top
+// of Amazon SQS, reserving and deleting messages in batches of up to 10
+// the way ReceiveMessage/DeleteMessageBatch expect.
+package sqs
+
+import (
+	"context"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"path"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/service/sqs"
+	"github.com/aws/aws-sdk-go/service/sqs/sqsiface"
+
+	"github.com/go-msgqueue/msgqueue"
+	"github.com/go-msgqueue/msgqueue/internal"
+	"github.com/go-msgqueue/msgqueue/internal/batcher"
+)
+
+// batchLimit is SQS's own limit for ReceiveMessage and
+// DeleteMessageBatch/SendMessageBatch.
+const batchLimit = 10
+
+type Queue struct {
+	sqs      sqsiface.SQSAPI
+	queueURL string
+	opt      *msgqueue.Options
+
+	delBatcher *batcher.Batcher
+
+	p *msgqueue.Processor
+}
+
+var _ msgqueue.Queue = (*Queue)(nil)
+var _ msgqueue.BatchBroker = (*Queue)(nil)
+
+// NewQueue wraps an existing SQS queue identified by queueURL. opt.Name
+// defaults to the last path segment of queueURL when unset.
+func NewQueue(api sqsiface.SQSAPI, queueURL string, opt *msgqueue.Options) *Queue {
+	if opt.Name == "" {
+		opt.Name = path.Base(queueURL)
+	}
+	opt.Init()
+
+	q := &Queue{
+		sqs:      api,
+		queueURL: queueURL,
+		opt:      opt,
+	}
+	q.delBatcher = batcher.New(&batcher.Options{
+		Handler:     q.DeleteBatch,
+		Splitter:    batcher.SizeSplitter(batchLimit),
+		ShouldRetry: isRetryable5xx,
+		// Bound how long a delete can sit buffered below batchLimit, so
+		// low-volume queues still delete well inside VisibilityTimeout
+		// instead of only flushing on the next Close or full batch.
+		Timeout: 5 * time.Second,
+	})
+	return q
+}
+
+func (q *Queue) Name() string {
+	return q.opt.Name
+}
+
+func (q *Queue) Len() (int, error) {
+	out, err := q.sqs.GetQueueAttributes(&sqs.GetQueueAttributesInput{
+		QueueUrl:       aws.String(q.queueURL),
+		AttributeNames: []*string{aws.String(sqs.QueueAttributeNameApproximateNumberOfMessages)},
+	})
+	if err != nil {
+		return 0, err
+	}
+
+	attr := out.Attributes[sqs.QueueAttributeNameApproximateNumberOfMessages]
+	if attr == nil {
+		return 0, nil
+	}
+
+	var n int
+	if _, err := fmt.Sscanf(*attr, "%d", &n); err != nil {
+		return 0, err
+	}
+	return n, nil
+}
+
+func (q *Queue) String() string {
+	return "Queue<Name=" + q.Name() + ">"
+}
+
+func (q *Queue) Options() *msgqueue.Options {
+	return q.opt
+}
+
+func (q *Queue) Processor() *msgqueue.Processor {
+	if q.p == nil {
+		q.p = msgqueue.NewProcessor(q, q.opt)
+	}
+	return q.p
+}
+
+// codec returns the codec configured for this queue, falling back to
+// msgpack so a plain SQS queue matches ironmq's default wire format.
+func (q *Queue) codec() msgqueue.Codec {
+	if q.opt.Codec != nil {
+		return q.opt.Codec
+	}
+	return msgqueue.MsgpackCodec{}
+}
+
+// packBody compresses body per Options.Compression/CompressThreshold
+// and, once it's still bigger than Options.MaxPayloadBytes, spills it to
+// Options.BlobStore - the same treatment ironmq.Queue gives a body,
+// since SQS's own 256KB message limit is exactly the kind of broker
+// limit Options.MaxPayloadBytes exists for. ReserveBatchN reverses both
+// steps via unpackBody.
+func (q *Queue) packBody(ctx context.Context, body []byte) ([]byte, error) {
+	body, err := msgqueue.CompressBody(body, q.opt.Compression, q.opt.CompressThreshold)
+	if err != nil {
+		return nil, err
+	}
+	return msgqueue.SpillIfTooLarge(ctx, q.opt.BlobStore, body, q.opt.MaxPayloadBytes)
+}
+
+func (q *Queue) unpackBody(ctx context.Context, body []byte) ([]byte, error) {
+	body, err := msgqueue.RestoreIfSpilled(ctx, q.opt.BlobStore, body)
+	if err != nil {
+		return nil, err
+	}
+	return msgqueue.DecompressBody(body)
+}
+
+// Add sends a single message. Producers that can batch their own sends
+// should call the AWS SDK directly; this path matches the rest of
+// msgqueue's one-message-at-a-time Queue interface.
+func (q *Queue) Add(msg *msgqueue.Message) error {
+	ctx, span := msgqueue.StartSpan(context.Background(), q.opt, "add", msg)
+	defer span.End()
+
+	body, err := msg.EncodeArgs(q.codec())
+	if err != nil {
+		return err
+	}
+
+	body, err = q.packBody(ctx, body)
+	if err != nil {
+		return err
+	}
+
+	out, err := q.sqs.SendMessage(&sqs.SendMessageInput{
+		QueueUrl: aws.String(q.queueURL),
+		// base64 because a compressed or spilled-reference body isn't
+		// guaranteed to be the valid UTF-8 SQS requires of MessageBody.
+		MessageBody: aws.String(base64.StdEncoding.EncodeToString(body)),
+	})
+	if err != nil {
+		return err
+	}
+
+	msg.Id = aws.StringValue(out.MessageId)
+	return nil
+}
+
+func (q *Queue) Call(args ...interface{}) error {
+	return q.Add(msgqueue.NewMessage(args...))
+}
+
+// CallOnce works like Call, but names the message from args and period
+// via msgqueue.Message.SetDelayName. Add does not check that name
+// against Options.NameLocker, so repeated calls within period are not
+// deduplicated and each sends its own message.
+func (q *Queue) CallOnce(period time.Duration, args ...interface{}) error {
+	msg := msgqueue.NewMessage(args...)
+	msg.SetDelayName(period, args...)
+	return q.Add(msg)
+}
+
+func (q *Queue) ReserveN(n int) ([]*msgqueue.Message, error) {
+	return q.ReserveBatchN(n)
+}
+
+// ReserveBatchN reserves up to n messages (capped at batchLimit, SQS's
+// own ReceiveMessage limit) using the queue's VisibilityTimeout and
+// WaitTimeSeconds.
+func (q *Queue) ReserveBatchN(n int) ([]*msgqueue.Message, error) {
+	ctx, span := msgqueue.StartSpan(context.Background(), q.opt, "reserve", nil)
+	defer span.End()
+
+	if n > batchLimit {
+		n = batchLimit
+	}
+
+	out, err := q.sqs.ReceiveMessage(&sqs.ReceiveMessageInput{
+		QueueUrl:            aws.String(q.queueURL),
+		MaxNumberOfMessages: aws.Int64(int64(n)),
+		VisibilityTimeout:   aws.Int64(int64(q.opt.ReservationTimeout.Seconds())),
+		WaitTimeSeconds:     aws.Int64(int64(q.opt.WaitTimeout.Seconds())),
+		AttributeNames:      []*string{aws.String("ApproximateReceiveCount")},
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	msgs := make([]*msgqueue.Message, len(out.Messages))
+	for i, m := range out.Messages {
+		raw, err := base64.StdEncoding.DecodeString(aws.StringValue(m.Body))
+		if err != nil {
+			return nil, err
+		}
+
+		body, err := q.unpackBody(ctx, raw)
+		if err != nil {
+			return nil, err
+		}
+
+		var reservedCount int
+		if s := m.Attributes[sqs.MessageSystemAttributeNameApproximateReceiveCount]; s != nil {
+			fmt.Sscanf(*s, "%d", &reservedCount)
+		}
+
+		msgs[i] = &msgqueue.Message{
+			Id:            aws.StringValue(m.MessageId),
+			Body:          body,
+			ReservationId: aws.StringValue(m.ReceiptHandle),
+			ReservedCount: reservedCount,
+			Codec:         q.codec(),
+		}
+	}
+	return msgs, nil
+}
+
+func (q *Queue) Release(msg *msgqueue.Message) error {
+	return q.ReleaseBatch([]*msgqueue.Message{msg})
+}
+
+// ReleaseBatch makes msgs visible again after msg.Delay, honoring the
+// backoff/retry-after a Processor set on it instead of always
+// redelivering immediately. SQS has no batch ChangeMessageVisibility
+// call, so this issues one request per message and returns the first
+// error it sees.
+func (q *Queue) ReleaseBatch(msgs []*msgqueue.Message) error {
+	_, span := msgqueue.StartSpan(context.Background(), q.opt, "release", nil)
+	defer span.End()
+
+	for _, msg := range msgs {
+		_, err := q.sqs.ChangeMessageVisibility(&sqs.ChangeMessageVisibilityInput{
+			QueueUrl:          aws.String(q.queueURL),
+			ReceiptHandle:     aws.String(msg.ReservationId),
+			VisibilityTimeout: aws.Int64(int64(msg.Delay / time.Second)),
+		})
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (q *Queue) Delete(msg *msgqueue.Message) error {
+	return q.delBatcher.Add(msg)
+}
+
+// DeleteBatch deletes up to batchLimit messages in a single
+// DeleteMessageBatch call, retrying the whole batch on a transient AWS
+// error and reporting which entries SQS itself rejected. Entries SQS
+// actually deleted are also retained via saveRetainedResult when
+// Options.Retention is set, same as ironmq.Queue.Delete.
+func (q *Queue) DeleteBatch(msgs []*msgqueue.Message) error {
+	_, span := msgqueue.StartSpan(context.Background(), q.opt, "delete", nil)
+	defer span.End()
+
+	if len(msgs) == 0 {
+		return errors.New("sqs: no messages to delete")
+	}
+
+	entries := make([]*sqs.DeleteMessageBatchRequestEntry, len(msgs))
+	for i, msg := range msgs {
+		entries[i] = &sqs.DeleteMessageBatchRequestEntry{
+			Id:            aws.String(msg.Id),
+			ReceiptHandle: aws.String(msg.ReservationId),
+		}
+	}
+
+	var out *sqs.DeleteMessageBatchOutput
+	err := batcher.Retry(3, isRetryable5xx, func() error {
+		var rerr error
+		out, rerr = q.sqs.DeleteMessageBatch(&sqs.DeleteMessageBatchInput{
+			QueueUrl: aws.String(q.queueURL),
+			Entries:  entries,
+		})
+		if rerr != nil {
+			return rerr
+		}
+		if len(out.Failed) > 0 {
+			return deleteBatchError(out.Failed)
+		}
+		return nil
+	})
+	if err != nil {
+		internal.Logf("sqs: DeleteMessageBatch failed: %s", err)
+	}
+
+	if q.opt.Retention > 0 && out != nil {
+		q.saveRetainedResults(msgs, out.Failed)
+	}
+	return err
+}
+
+// saveRetainedResults retains every msg in msgs that isn't named in
+// failed, so GetTaskInfo can still answer queries after SQS deletes it.
+func (q *Queue) saveRetainedResults(msgs []*msgqueue.Message, failed []*sqs.BatchResultErrorEntry) {
+	failedIDs := make(map[string]bool, len(failed))
+	for _, f := range failed {
+		failedIDs[aws.StringValue(f.Id)] = true
+	}
+
+	for _, msg := range msgs {
+		if failedIDs[msg.Id] {
+			continue
+		}
+		if err := q.saveRetainedResult(msg); err != nil {
+			internal.Logf("sqs: saving retained result for %s failed: %s", msg.Id, err)
+		}
+	}
+}
+
+// saveRetainedResult mirrors ironmq.Queue.saveRetainedResult: it moves a
+// compact record of msg into Redis with a TTL of Options.Retention, so
+// GetTaskInfo can answer queries about jobs already deleted from SQS. A
+// Handler may already have stored the real result via
+// ResultWriter.Write under the same key, so this only fills in
+// Result/LastErr when nothing has been written yet.
+func (q *Queue) saveRetainedResult(msg *msgqueue.Message) error {
+	if q.opt.Redis == nil {
+		return errors.New("sqs: Options.Retention requires Options.Redis")
+	}
+
+	ctx := context.Background()
+	result, lastErr := msg.Result, msg.Err
+	if existing, err := msgqueue.LoadTaskInfo(ctx, q.opt.Redis, q.Name(), msg.Id); err == nil {
+		if result == nil {
+			result = existing.Result
+		}
+		if lastErr == "" {
+			lastErr = existing.LastErr
+		}
+	}
+
+	state := msgqueue.TaskStateCompleted
+	if lastErr != "" {
+		state = msgqueue.TaskStateFailed
+	}
+
+	info := &msgqueue.TaskInfo{
+		ID:          msg.Id,
+		State:       state,
+		CompletedAt: time.Now(),
+		Result:      result,
+		Retention:   q.opt.Retention,
+		LastErr:     lastErr,
+		Retried:     msg.ReservedCount,
+	}
+	return msgqueue.SaveTaskInfo(ctx, q.opt.Redis, q.Name(), info)
+}
+
+// GetTaskInfo returns the retained result of a message previously
+// processed with Options.Retention set, or an error once the retention
+// TTL has expired.
+func (q *Queue) GetTaskInfo(id string) (*msgqueue.TaskInfo, error) {
+	if q.opt.Redis == nil {
+		return nil, errors.New("sqs: GetTaskInfo requires Options.Redis")
+	}
+	return msgqueue.LoadTaskInfo(context.Background(), q.opt.Redis, q.Name(), id)
+}
+
+func (q *Queue) Purge() error {
+	_, err := q.sqs.PurgeQueue(&sqs.PurgeQueueInput{
+		QueueUrl: aws.String(q.queueURL),
+	})
+	return err
+}
+
+// Close is CloseTimeout with 30 seconds timeout.
+func (q *Queue) Close() error {
+	return q.CloseTimeout(30 * time.Second)
+}
+
+func (q *Queue) CloseTimeout(timeout time.Duration) error {
+	var firstErr error
+	if q.p != nil {
+		if err := q.p.StopTimeout(timeout); err != nil && firstErr == nil {
+			firstErr = err
+		}
+	}
+	if err := q.delBatcher.Flush(); err != nil && firstErr == nil {
+		firstErr = err
+	}
+	if err := q.delBatcher.Close(); err != nil && firstErr == nil {
+		firstErr = err
+	}
+	return firstErr
+}
+
+// partialBatchError is returned when DeleteMessageBatch itself succeeds
+// but rejects some entries. It exposes Retryable so isRetryable5xx can
+// treat a partial failure the same as a request-level 5xx, instead of
+// only retrying when SQS fails the whole batch.
+type partialBatchError struct {
+	failed []*sqs.BatchResultErrorEntry
+}
+
+func (e *partialBatchError) Error() string {
+	msg := "sqs: DeleteMessageBatch partially failed:"
+	for _, f := range e.failed {
+		msg += " " + aws.StringValue(f.Id) + ": " + aws.StringValue(f.Message) + ";"
+	}
+	return msg
+}
+
+// Retryable reports false if any rejected entry has SenderFault set,
+// meaning the request itself was wrong (e.g. an unknown receipt handle)
+// and retrying it verbatim would just fail again.
+func (e *partialBatchError) Retryable() bool {
+	for _, f := range e.failed {
+		if aws.BoolValue(f.SenderFault) {
+			return false
+		}
+	}
+	return true
+}
+
+func deleteBatchError(failed []*sqs.BatchResultErrorEntry) error {
+	return &partialBatchError{failed: failed}
+}
+
+// isRetryable5xx reports whether err looks like a transient SQS-side
+// failure (a 5xx, or a partialBatchError with no SenderFault entries)
+// worth retrying, as opposed to a request the caller got wrong.
+// Throttling errors, which SQS returns as 4xx, are not retried.
+func isRetryable5xx(err error) bool {
+	if aerr, ok := err.(awserr.RequestFailure); ok {
+		return aerr.StatusCode() >= 500
+	}
+	if perr, ok := err.(interface{ Retryable() bool }); ok {
+		return perr.Retryable()
+	}
+	return false
+}
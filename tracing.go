@@ -0,0 +1,42 @@
+package msgqueue
+
+import (
+	"context"
+
+	"go.opentelemetry.io/otel/api/trace"
+	"go.opentelemetry.io/otel/label"
+)
+
+// tracerName identifies msgqueue's own spans among everything else a
+// process emits.
+const tracerName = "github.com/go-msgqueue/msgqueue"
+
+// tracer returns opt.TracerProvider's tracer, or the global no-op
+// tracer when unset, so instrumentation is zero-cost until a caller
+// opts in.
+func tracer(opt *Options) trace.Tracer {
+	if opt.TracerProvider == nil {
+		return trace.NoopTracerProvider().Tracer(tracerName)
+	}
+	return opt.TracerProvider.Tracer(tracerName)
+}
+
+// StartSpan starts a span named "msgqueue.<op>" tagged with the
+// messaging.* attributes recommended by the OpenTelemetry semantic
+// conventions for messaging systems, plus retry_count since retries are
+// central to how msgqueue behaves. Brokers call it around Add/Reserve/
+// Delete/Release; processor.Processor calls it around handler
+// invocation.
+func StartSpan(ctx context.Context, opt *Options, op string, msg *Message) (context.Context, trace.Span) {
+	attrs := []label.KeyValue{
+		label.String("messaging.system", "msgqueue"),
+		label.String("messaging.destination", opt.Name),
+	}
+	if msg != nil {
+		attrs = append(attrs,
+			label.String("messaging.message_id", msg.Id),
+			label.Int("retry_count", msg.ReservedCount),
+		)
+	}
+	return tracer(opt).Start(ctx, "msgqueue."+op, trace.WithAttributes(attrs...))
+}